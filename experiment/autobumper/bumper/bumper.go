@@ -0,0 +1,760 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package bumper implements the logic for the autobumper tool, which keeps
+// Prow's own images and the images it tells jobs to run up to date by
+// watching an upstream source of truth and opening a pull/merge request
+// whenever it drifts.
+package bumper
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"k8s.io/test-infra/prow/config/secret"
+)
+
+// latestVersion tells parseUpstreamImageVersion to resolve against whatever
+// tag the upstream source currently advertises, as opposed to a version the
+// caller pins explicitly.
+const latestVersion = "latest"
+
+// prowImageVersionRegexp extracts the tag of a gcr.io/k8s-prow image
+// reference, e.g. matching "image: gcr.io/k8s-prow/deck:v20200717-cf288082e1"
+// against a line scraped from an upstream Prow deployment manifest.
+var prowImageVersionRegexp = regexp.MustCompile(`image:\s+(gcr\.io/k8s-prow/[a-zA-Z0-9_-]+):([a-zA-Z0-9_-]+)`)
+
+// The provider names below are the remote hosting services the bumper knows
+// how to open a pull/merge request against. They are plain strings so they
+// can be used directly as the value of the --pr-provider flag.
+const (
+	// GitHubProvider opens a pull request against a github.com or GitHub
+	// Enterprise repository.
+	GitHubProvider = "github"
+	// GitLabProvider opens a merge request against a GitLab (gitlab.com or
+	// self-hosted) project.
+	GitLabProvider = "gitlab"
+	// BitbucketProvider opens a pull request against a Bitbucket Cloud
+	// repository via the 2.0 REST API.
+	BitbucketProvider = "bitbucket"
+)
+
+// Options is the set of configuration the autobumper needs to bump Prow's
+// own images and/or the test images it references, and to open a pull
+// request recording the bump.
+type Options struct {
+	// GitHubOrg is the GitHub org of the PR to be created.
+	GitHubOrg string
+	// GitHubRepo is the GitHub repo of the PR to be created.
+	GitHubRepo string
+	// GitHubLogin is the GitHub username to use when creating the PR.
+	GitHubLogin string
+	// GitHubToken is the GitHub token itself (not a path to a file holding
+	// it), embedded in the push URL and sent with API requests.
+	GitHubToken string
+	// GitName is the name to use on the git commit.
+	GitName string
+	// GitEmail is the email to use on the git commit.
+	GitEmail string
+
+	// GitLabProjectID is the numeric ID of the GitLab project to open the
+	// merge request against. Either this or Namespace/Project must be set.
+	GitLabProjectID string
+	// GitLabNamespace is the namespace (group or user) owning the project,
+	// used together with GitLabProject when GitLabProjectID is unset.
+	GitLabNamespace string
+	// GitLabProject is the project name, used together with GitLabNamespace
+	// when GitLabProjectID is unset.
+	GitLabProject string
+	// GitLabToken is the GitLab personal/project access token itself (not a
+	// path to a file holding it). It needs the `api` and `write_repository`
+	// scopes.
+	GitLabToken string
+	// GitLabHost is the host to push the bump branch to and to embed in
+	// merge request URLs, e.g. "gitlab.example.com" for a self-hosted
+	// instance. Defaults to "gitlab.com" when empty.
+	GitLabHost string
+	// GitLabAPIBase is the base URL of the GitLab API, e.g.
+	// "https://gitlab.example.com/api/v4" for a self-hosted instance.
+	// Defaults to "https://gitlab.com/api/v4" when empty.
+	GitLabAPIBase string
+
+	// BitbucketWorkspace is the Bitbucket Cloud workspace owning the repo.
+	BitbucketWorkspace string
+	// BitbucketRepo is the Bitbucket Cloud repo slug.
+	BitbucketRepo string
+	// BitbucketUser is the Bitbucket Cloud username paired with
+	// BitbucketAppPassword.
+	BitbucketUser string
+	// BitbucketAppPassword is the Bitbucket Cloud app password itself (not a
+	// path to a file holding it), scoped to `repository:write` and
+	// `pullrequest:write`.
+	BitbucketAppPassword string
+	// BitbucketHost is the host to push the bump branch to, e.g.
+	// "bitbucket.example.com" for a self-hosted Bitbucket Server/Data
+	// Center instance. Defaults to "bitbucket.org" when empty.
+	BitbucketHost string
+	// BitbucketAPIBase is the base URL of the Bitbucket REST API, e.g.
+	// "https://bitbucket.example.com/rest/api/2.0" for a self-hosted
+	// instance. Defaults to "https://api.bitbucket.org/2.0" when empty.
+	BitbucketAPIBase string
+
+	// PRProvider selects which remote hosting service to open the pull or
+	// merge request against. Defaults to GitHubProvider when empty.
+	PRProvider string
+
+	// RemoteName is the name of the remote to push the bump branch to.
+	RemoteName string
+	// RemoteBranch is the name of the branch to push the bump commit to.
+	RemoteBranch string
+	// BaseBranch is the branch the pull/merge request is opened against.
+	// Defaults to "master" when empty.
+	BaseBranch string
+
+	// BumpProwImages controls whether Prow's own component images get
+	// bumped.
+	BumpProwImages bool
+	// BumpTestImages controls whether the test images referenced by job
+	// configs get bumped.
+	BumpTestImages bool
+	// TargetVersion is the version to bump to, or latestVersion to resolve
+	// whatever upstream currently publishes.
+	TargetVersion string
+	// CurrentVersion is the version being bumped from, surfaced to
+	// *Template Options fields as Bump.FromVersion.
+	CurrentVersion string
+	// UpstreamURLBase is the address Run fetches to resolve TargetVersion
+	// when it is empty or latestVersion.
+	UpstreamURLBase string
+	// IncludedConfigPaths is the list of paths to search for image
+	// references to bump. At least one must be given.
+	IncludedConfigPaths []string
+	// ExcludedConfigPaths is the list of paths to skip while walking
+	// IncludedConfigPaths.
+	ExcludedConfigPaths []string
+
+	// OncallAddress, when set, is queried to assign the opened PR to
+	// whoever is currently on call.
+	OncallAddress string
+
+	// SkipPullRequest, when true, bumps the local tree and commits the
+	// change but does not push or open a pull/merge request, so none of
+	// the provider-specific credentials above need to be set.
+	SkipPullRequest bool
+
+	// CommitMessageTemplate, PRTitleTemplate, PRBodyTemplate and
+	// RemoteBranchTemplate are text/template strings evaluated against a
+	// TemplateContext to produce the git commit message, PR/MR title, PR/MR
+	// body and bump branch name respectively. Each defaults to the bumper's
+	// historical hard-coded format when left empty.
+	CommitMessageTemplate string
+	PRTitleTemplate       string
+	PRBodyTemplate        string
+	RemoteBranchTemplate  string
+
+	// VerifySignatures, when true, makes the bumper run `cosign verify`
+	// against the gcr.io/k8s-prow image whose tag the upstream version is
+	// resolved from, aborting the bump if verification fails. Only that one
+	// image is verified; the other Prow images and the test images are not.
+	VerifySignatures bool
+	// SignatureKeyRef is the cosign public key to verify against: a local
+	// file path, a `k8s://<namespace>/<secret>` reference, or, for keyless
+	// verification, "<certificate-identity>|<certificate-oidc-issuer>".
+	// Required when VerifySignatures is true.
+	SignatureKeyRef string
+
+	// UpstreamCacheDir is where resolved upstream responses are cached on
+	// disk, keyed by URL. Responses are cached only when this, Offline or
+	// MaxCacheAge is set; when this is empty in that case the cache lives in
+	// $XDG_CACHE_HOME/prow-bumper (or ~/.cache/prow-bumper).
+	UpstreamCacheDir string
+	// Offline, when true, resolves upstream versions only from the cache,
+	// failing if an entry is missing or older than MaxCacheAge.
+	Offline bool
+	// MaxCacheAge is the oldest a cache entry may be before Offline mode
+	// refuses to use it. Zero means no age limit.
+	MaxCacheAge time.Duration
+}
+
+// PRProvider abstracts opening a pull/merge request against a remote code
+// review system, so the bumper can run the same bump workflow against
+// GitHub, GitLab or Bitbucket Cloud.
+type PRProvider interface {
+	// Name is the human readable name of the provider, e.g. "GitLab". The
+	// default PR/MR body does not include it.
+	Name() string
+	// RemoteURL returns the authenticated git remote URL the bump branch
+	// should be pushed to.
+	RemoteURL() (string, error)
+	// EnsurePR creates, or updates if one already exists for remoteBranch,
+	// the pull/merge request and returns its URL.
+	EnsurePR(title, body, assignee, remoteBranch string) (string, error)
+	// RequiredScopes documents the minimum token/app-password scopes the
+	// configured credentials must carry. validateOptions does not consult
+	// it, and it is not checked against the live token.
+	RequiredScopes() []string
+}
+
+func newPRProvider(o *Options) (PRProvider, error) {
+	switch provider(o) {
+	case GitHubProvider:
+		return &githubProvider{o: o}, nil
+	case GitLabProvider:
+		return &gitlabProvider{o: o}, nil
+	case BitbucketProvider:
+		return &bitbucketProvider{o: o}, nil
+	default:
+		return nil, fmt.Errorf("unknown --pr-provider %q, must be one of %q, %q or %q", o.PRProvider, GitHubProvider, GitLabProvider, BitbucketProvider)
+	}
+}
+
+// provider returns the configured PRProvider, defaulting to GitHub for
+// backwards compatibility with configs that predate --pr-provider.
+func provider(o *Options) string {
+	if o.PRProvider == "" {
+		return GitHubProvider
+	}
+	return o.PRProvider
+}
+
+// baseBranchOrDefault returns base, or "master" when base is empty.
+func baseBranchOrDefault(base string) string {
+	if base == "" {
+		return "master"
+	}
+	return base
+}
+
+// withAssigneeNote appends a "/cc @assignee" note to body so every provider
+// records the assignee even where its create-PR/MR endpoint has no
+// dedicated assignee field (or, as with GitHub, needs a second call we'd
+// rather not make just to set one).
+func withAssigneeNote(body, assignee string) string {
+	if assignee == "" {
+		return body
+	}
+	return fmt.Sprintf("%s\n\n/cc @%s", body, assignee)
+}
+
+// jsonRequest issues a method request to url with the given auth header,
+// sending payload as a JSON body (unless nil, e.g. for a GET) and decoding a
+// successful JSON response into out (unless nil).
+func jsonRequest(client *http.Client, method, url, authHeader, authValue string, payload, out interface{}) error {
+	var reqBody io.Reader
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request body: %w", err)
+		}
+		reqBody = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequest(method, url, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to build request to %q: %w", url, err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set(authHeader, authValue)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request to %q failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response from %q: %w", url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("request to %q returned %s: %s", url, resp.Status, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("failed to decode response from %q: %w", url, err)
+	}
+	return nil
+}
+
+// postJSON POSTs payload as JSON to url with the given auth header, decoding
+// a successful JSON response into out.
+func postJSON(client *http.Client, url, authHeader, authValue string, payload, out interface{}) error {
+	return jsonRequest(client, http.MethodPost, url, authHeader, authValue, payload, out)
+}
+
+// getJSON GETs url with the given auth header, decoding a successful JSON
+// response into out.
+func getJSON(client *http.Client, url, authHeader, authValue string, out interface{}) error {
+	return jsonRequest(client, http.MethodGet, url, authHeader, authValue, nil, out)
+}
+
+type githubProvider struct {
+	o       *Options
+	client  *http.Client
+	apiBase string
+}
+
+func (p *githubProvider) Name() string { return "GitHub" }
+
+func (p *githubProvider) httpClient() *http.Client {
+	if p.client != nil {
+		return p.client
+	}
+	return http.DefaultClient
+}
+
+func (p *githubProvider) api() string {
+	if p.apiBase != "" {
+		return p.apiBase
+	}
+	return "https://api.github.com"
+}
+
+func (p *githubProvider) RemoteURL() (string, error) {
+	return fmt.Sprintf("https://%s:%s@github.com/%s/%s.git", p.o.GitHubLogin, p.o.GitHubToken, p.o.GitHubOrg, p.o.GitHubRepo), nil
+}
+
+type githubPullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+// EnsurePR creates a pull request for remoteBranch, or, if one is already
+// open for it, updates its title and body instead of failing with a
+// duplicate-PR error on the next scheduled run.
+func (p *githubProvider) EnsurePR(title, body, assignee, remoteBranch string) (string, error) {
+	authHeader, authValue := "Authorization", "token "+p.o.GitHubToken
+
+	var open []githubPullRequest
+	listURL := fmt.Sprintf("%s/repos/%s/%s/pulls?state=open&head=%s", p.api(), p.o.GitHubOrg, p.o.GitHubRepo, url.QueryEscape(p.o.GitHubOrg+":"+remoteBranch))
+	if err := getJSON(p.httpClient(), listURL, authHeader, authValue, &open); err != nil {
+		return "", fmt.Errorf("failed to list existing GitHub pull requests: %w", err)
+	}
+
+	payload := map[string]string{
+		"title": title,
+		"body":  withAssigneeNote(body, assignee),
+	}
+	if len(open) > 0 {
+		var pr githubPullRequest
+		updateURL := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", p.api(), p.o.GitHubOrg, p.o.GitHubRepo, open[0].Number)
+		if err := jsonRequest(p.httpClient(), http.MethodPatch, updateURL, authHeader, authValue, payload, &pr); err != nil {
+			return "", fmt.Errorf("failed to update existing GitHub pull request: %w", err)
+		}
+		return pr.HTMLURL, nil
+	}
+
+	payload["head"] = remoteBranch
+	payload["base"] = baseBranchOrDefault(p.o.BaseBranch)
+	var pr githubPullRequest
+	if err := postJSON(p.httpClient(), fmt.Sprintf("%s/repos/%s/%s/pulls", p.api(), p.o.GitHubOrg, p.o.GitHubRepo), authHeader, authValue, payload, &pr); err != nil {
+		return "", fmt.Errorf("failed to create GitHub pull request: %w", err)
+	}
+	return pr.HTMLURL, nil
+}
+
+func (p *githubProvider) RequiredScopes() []string { return []string{"repo"} }
+
+type gitlabProvider struct {
+	o       *Options
+	client  *http.Client
+	apiBase string
+}
+
+func (p *gitlabProvider) Name() string { return "GitLab" }
+
+func (p *gitlabProvider) httpClient() *http.Client {
+	if p.client != nil {
+		return p.client
+	}
+	return http.DefaultClient
+}
+
+func (p *gitlabProvider) api() string {
+	if p.apiBase != "" {
+		return p.apiBase
+	}
+	if p.o.GitLabAPIBase != "" {
+		return p.o.GitLabAPIBase
+	}
+	return "https://gitlab.com/api/v4"
+}
+
+func (p *gitlabProvider) host() string {
+	if p.o.GitLabHost != "" {
+		return p.o.GitLabHost
+	}
+	return "gitlab.com"
+}
+
+func (p *gitlabProvider) project() string {
+	if p.o.GitLabProjectID != "" {
+		return p.o.GitLabProjectID
+	}
+	return fmt.Sprintf("%s/%s", p.o.GitLabNamespace, p.o.GitLabProject)
+}
+
+func (p *gitlabProvider) RemoteURL() (string, error) {
+	return fmt.Sprintf("https://oauth2:%s@%s/%s.git", p.o.GitLabToken, p.host(), p.project()), nil
+}
+
+type gitlabMergeRequest struct {
+	IID    int    `json:"iid"`
+	WebURL string `json:"web_url"`
+}
+
+// EnsurePR creates a merge request for remoteBranch, or, if one is already
+// open for it, updates its title and description instead of failing with a
+// duplicate-MR error on the next scheduled run.
+func (p *gitlabProvider) EnsurePR(title, body, assignee, remoteBranch string) (string, error) {
+	authHeader, authValue := "PRIVATE-TOKEN", p.o.GitLabToken
+	projectPath := fmt.Sprintf("%s/projects/%s/merge_requests", p.api(), url.PathEscape(p.project()))
+
+	var open []gitlabMergeRequest
+	listURL := fmt.Sprintf("%s?state=opened&source_branch=%s", projectPath, url.QueryEscape(remoteBranch))
+	if err := getJSON(p.httpClient(), listURL, authHeader, authValue, &open); err != nil {
+		return "", fmt.Errorf("failed to list existing GitLab merge requests: %w", err)
+	}
+
+	payload := map[string]string{
+		"title":       title,
+		"description": withAssigneeNote(body, assignee),
+	}
+	if len(open) > 0 {
+		var mr gitlabMergeRequest
+		updateURL := fmt.Sprintf("%s/%d", projectPath, open[0].IID)
+		if err := jsonRequest(p.httpClient(), http.MethodPut, updateURL, authHeader, authValue, payload, &mr); err != nil {
+			return "", fmt.Errorf("failed to update existing GitLab merge request: %w", err)
+		}
+		return mr.WebURL, nil
+	}
+
+	payload["source_branch"] = remoteBranch
+	payload["target_branch"] = baseBranchOrDefault(p.o.BaseBranch)
+	var mr gitlabMergeRequest
+	if err := postJSON(p.httpClient(), projectPath, authHeader, authValue, payload, &mr); err != nil {
+		return "", fmt.Errorf("failed to create GitLab merge request: %w", err)
+	}
+	return mr.WebURL, nil
+}
+
+func (p *gitlabProvider) RequiredScopes() []string { return []string{"api", "write_repository"} }
+
+type bitbucketProvider struct {
+	o       *Options
+	client  *http.Client
+	apiBase string
+}
+
+func (p *bitbucketProvider) Name() string { return "Bitbucket" }
+
+func (p *bitbucketProvider) httpClient() *http.Client {
+	if p.client != nil {
+		return p.client
+	}
+	return http.DefaultClient
+}
+
+func (p *bitbucketProvider) api() string {
+	if p.apiBase != "" {
+		return p.apiBase
+	}
+	if p.o.BitbucketAPIBase != "" {
+		return p.o.BitbucketAPIBase
+	}
+	return "https://api.bitbucket.org/2.0"
+}
+
+func (p *bitbucketProvider) host() string {
+	if p.o.BitbucketHost != "" {
+		return p.o.BitbucketHost
+	}
+	return "bitbucket.org"
+}
+
+func (p *bitbucketProvider) RemoteURL() (string, error) {
+	return fmt.Sprintf("https://%s:%s@%s/%s/%s.git", p.o.BitbucketUser, p.o.BitbucketAppPassword, p.host(), p.o.BitbucketWorkspace, p.o.BitbucketRepo), nil
+}
+
+type bitbucketPullRequest struct {
+	ID     int `json:"id"`
+	Source struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+	} `json:"source"`
+	Links struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+// EnsurePR creates a pull request for remoteBranch, or, if one is already
+// open for it, updates its title and description instead of failing with a
+// duplicate-PR error on the next scheduled run.
+func (p *bitbucketProvider) EnsurePR(title, body, assignee, remoteBranch string) (string, error) {
+	authHeader := "Authorization"
+	authValue := "Basic " + base64.StdEncoding.EncodeToString([]byte(p.o.BitbucketUser+":"+p.o.BitbucketAppPassword))
+	repoPath := fmt.Sprintf("%s/repositories/%s/%s/pullrequests", p.api(), p.o.BitbucketWorkspace, p.o.BitbucketRepo)
+
+	var open struct {
+		Values []bitbucketPullRequest `json:"values"`
+	}
+	if err := getJSON(p.httpClient(), repoPath+"?state=OPEN", authHeader, authValue, &open); err != nil {
+		return "", fmt.Errorf("failed to list existing Bitbucket pull requests: %w", err)
+	}
+	var existing *bitbucketPullRequest
+	for i := range open.Values {
+		if open.Values[i].Source.Branch.Name == remoteBranch {
+			existing = &open.Values[i]
+			break
+		}
+	}
+
+	payload := map[string]interface{}{
+		"title":       title,
+		"description": withAssigneeNote(body, assignee),
+	}
+	if existing != nil {
+		var pr bitbucketPullRequest
+		updateURL := fmt.Sprintf("%s/%d", repoPath, existing.ID)
+		if err := jsonRequest(p.httpClient(), http.MethodPut, updateURL, authHeader, authValue, payload, &pr); err != nil {
+			return "", fmt.Errorf("failed to update existing Bitbucket pull request: %w", err)
+		}
+		return pr.Links.HTML.Href, nil
+	}
+
+	payload["source"] = map[string]interface{}{"branch": map[string]string{"name": remoteBranch}}
+	payload["destination"] = map[string]interface{}{"branch": map[string]string{"name": baseBranchOrDefault(p.o.BaseBranch)}}
+	var pr bitbucketPullRequest
+	if err := postJSON(p.httpClient(), repoPath, authHeader, authValue, payload, &pr); err != nil {
+		return "", fmt.Errorf("failed to create Bitbucket pull request: %w", err)
+	}
+	return pr.Links.HTML.Href, nil
+}
+
+func (p *bitbucketProvider) RequiredScopes() []string {
+	return []string{"repository:write", "pullrequest:write"}
+}
+
+// validateOptions makes sure that the options are set correctly, for both
+// bump configuration and, unless SkipPullRequest is set, the configured
+// PRProvider's required fields.
+func validateOptions(o *Options) error {
+	if !o.BumpProwImages && !o.BumpTestImages {
+		return fmt.Errorf("at least one of BumpProwImages or BumpTestImages must be true")
+	}
+	if o.BumpProwImages && o.BumpTestImages && o.TargetVersion != "" && o.TargetVersion != latestVersion {
+		return fmt.Errorf("the target version must be %q if both BumpProwImages and BumpTestImages are true", latestVersion)
+	}
+	if o.VerifySignatures && o.TargetVersion != "" && o.TargetVersion != latestVersion {
+		return fmt.Errorf("TargetVersion must be empty or %q when VerifySignatures is true: a pinned version is never resolved from upstream, so it can never be verified", latestVersion)
+	}
+	if len(o.IncludedConfigPaths) == 0 {
+		return fmt.Errorf("IncludedConfigPaths is empty, must include at least one config path")
+	}
+	if err := validateTemplates(o); err != nil {
+		return err
+	}
+	if err := validateVerifySignatures(o); err != nil {
+		return err
+	}
+	if o.SkipPullRequest {
+		return nil
+	}
+	if o.RemoteBranch == "" {
+		return fmt.Errorf("RemoteBranch cannot be empty when SkipPullRequest is false")
+	}
+	switch provider(o) {
+	case GitHubProvider:
+		return validateGitHubOptions(o)
+	case GitLabProvider:
+		return validateGitLabOptions(o)
+	case BitbucketProvider:
+		return validateBitbucketOptions(o)
+	default:
+		return fmt.Errorf("unknown --pr-provider %q, must be one of %q, %q or %q", o.PRProvider, GitHubProvider, GitLabProvider, BitbucketProvider)
+	}
+}
+
+func validateGitHubOptions(o *Options) error {
+	if o.GitHubToken == "" {
+		return fmt.Errorf("GitHubToken must not be empty when SkipPullRequest is false")
+	}
+	if o.GitHubOrg == "" {
+		return fmt.Errorf("GitHubOrg cannot be empty when SkipPullRequest is false")
+	}
+	if o.GitHubRepo == "" {
+		return fmt.Errorf("GitHubRepo cannot be empty when SkipPullRequest is false")
+	}
+	return nil
+}
+
+func validateGitLabOptions(o *Options) error {
+	if o.GitLabToken == "" {
+		return fmt.Errorf("GitLabToken must not be empty when SkipPullRequest is false")
+	}
+	if o.GitLabProjectID == "" && (o.GitLabNamespace == "" || o.GitLabProject == "") {
+		return fmt.Errorf("either GitLabProjectID or both GitLabNamespace and GitLabProject must be set when SkipPullRequest is false")
+	}
+	return nil
+}
+
+func validateBitbucketOptions(o *Options) error {
+	if o.BitbucketAppPassword == "" {
+		return fmt.Errorf("BitbucketAppPassword must not be empty when SkipPullRequest is false")
+	}
+	if o.BitbucketUser == "" {
+		return fmt.Errorf("BitbucketUser cannot be empty when SkipPullRequest is false")
+	}
+	if o.BitbucketWorkspace == "" || o.BitbucketRepo == "" {
+		return fmt.Errorf("both BitbucketWorkspace and BitbucketRepo must be set when SkipPullRequest is false")
+	}
+	return nil
+}
+
+// hideSecretsWriter wraps a delegate writer, censoring any registered
+// secrets before they reach it. It is used to keep credentials out of the
+// logs produced by commands run through call.
+type hideSecretsWriter struct {
+	delegate io.Writer
+	censor   *secret.Agent
+}
+
+func (w hideSecretsWriter) Write(content []byte) (int, error) {
+	censored := w.censor.Censor(content)
+	n, err := w.delegate.Write(censored)
+	if n > len(content) {
+		n = len(content)
+	}
+	if n < len(content) && err == nil {
+		err = io.ErrShortWrite
+	}
+	return len(content), err
+}
+
+// call runs the given command with its output piped through stdout/stderr,
+// which are typically hideSecretsWriters so that credentials baked into
+// command output never hit the build log.
+func call(stdout, stderr io.Writer, cmd string, args ...string) error {
+	c := exec.Command(cmd, args...)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	return c.Run()
+}
+
+// isUnderPath tells whether file lives under one of paths, treating each
+// entry of paths as a directory prefix rather than a plain string prefix.
+func isUnderPath(file string, paths []string) bool {
+	for _, p := range paths {
+		if strings.HasPrefix(file, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type oncallResult struct {
+	Oncall struct {
+		TestInfra string `json:"testinfra"`
+	} `json:"Oncall"`
+}
+
+// getAssignment fetches the current test-infra oncaller from oncallURL and
+// returns a human readable string describing who should be assigned the
+// bump PR. oncallURL may be empty, in which case no assignment is made.
+func getAssignment(oncallURL string) string {
+	if oncallURL == "" {
+		return ""
+	}
+	resp, err := http.Get(oncallURL)
+	if err != nil {
+		return fmt.Sprintf("error requesting oncall address: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Sprintf("error reading oncall response: %v", err)
+	}
+	oncall := oncallResult{}
+	if err := json.Unmarshal(body, &oncall); err != nil {
+		return fmt.Sprintf("error unmarshaling oncall response %q: %v", string(body), err)
+	}
+	if oncall.Oncall.TestInfra == "" {
+		return "Nobody is currently oncall, skipping assignment"
+	}
+	return fmt.Sprintf("test-infra oncall: %s", oncall.Oncall.TestInfra)
+}
+
+// parseUpstreamImageVersion fetches upstreamAddress via fetch and scrapes
+// the tag of the first gcr.io/k8s-prow image reference it finds. A nil
+// fetch defaults to a plain, uncached HTTP GET. When verifier is non-nil,
+// the resolved image is also verified (e.g. via cosign) before its tag is
+// returned, and the verified digest is returned alongside it so callers can
+// pin the bump to it instead of a floating tag.
+func parseUpstreamImageVersion(upstreamAddress string, verifier signatureVerifier, fetch fetcher) (tag, digest string, err error) {
+	if upstreamAddress == "" {
+		return "", "", fmt.Errorf("upstream address must not be empty")
+	}
+	if fetch == nil {
+		fetch = httpFetcher{}
+	}
+	body, err := fetch.Fetch(upstreamAddress)
+	if err != nil {
+		return "", "", err
+	}
+	matches := prowImageVersionRegexp.FindStringSubmatch(string(body))
+	if matches == nil {
+		return "", "", fmt.Errorf("could not find a gcr.io/k8s-prow image reference in %q", upstreamAddress)
+	}
+	image, tag := matches[1], matches[2]
+	if verifier == nil {
+		return tag, "", nil
+	}
+	digest, err = verifier.VerifyImage(fmt.Sprintf("%s:%s", image, tag))
+	if err != nil {
+		return "", "", fmt.Errorf("refusing to bump to unverified image %s:%s: %w", image, tag, err)
+	}
+	return tag, digest, nil
+}
+
+// cdToRootDir changes the working directory to the root of the Bazel
+// workspace, which is required for the bumper's file-walking logic to see
+// config paths relative to the repo root.
+func cdToRootDir() error {
+	buildWorkspaceDirectory := os.Getenv("BUILD_WORKSPACE_DIRECTORY")
+	if buildWorkspaceDirectory == "" {
+		return fmt.Errorf("BUILD_WORKSPACE_DIRECTORY is not set")
+	}
+	if err := os.Chdir(buildWorkspaceDirectory); err != nil {
+		return fmt.Errorf("failed to chdir to %q: %w", buildWorkspaceDirectory, err)
+	}
+	return nil
+}
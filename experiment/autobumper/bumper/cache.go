@@ -0,0 +1,197 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bumper
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// defaultUpstreamCacheDir is where cacheFetcher stores entries when
+// Options.UpstreamCacheDir is unset.
+func defaultUpstreamCacheDir() string {
+	cacheHome := os.Getenv("XDG_CACHE_HOME")
+	if cacheHome == "" {
+		cacheHome = filepath.Join(os.Getenv("HOME"), ".cache")
+	}
+	return filepath.Join(cacheHome, "prow-bumper")
+}
+
+// fetcher resolves the body of a URL, optionally consulting/populating an
+// on-disk cache, so parseUpstreamImageVersion can be tested against a fake
+// and so real runs can avoid refetching upstream sources that haven't
+// changed.
+type fetcher interface {
+	Fetch(url string) (body []byte, err error)
+}
+
+// cacheEntry is the on-disk representation of a single cached fetch.
+type cacheEntry struct {
+	URL          string    `json:"url"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last-modified,omitempty"`
+	FetchedAt    time.Time `json:"fetched-at"`
+	Body         string    `json:"body"`
+}
+
+// cacheFetcher fetches URLs over HTTP, using conditional GETs against an
+// on-disk cache so unchanged upstreams short-circuit with a 304, and
+// optionally serving purely from that cache in Offline mode.
+type cacheFetcher struct {
+	dir     string
+	offline bool
+	maxAge  time.Duration
+	client  *http.Client
+}
+
+func newCacheFetcher(o *Options) *cacheFetcher {
+	dir := o.UpstreamCacheDir
+	if dir == "" {
+		dir = defaultUpstreamCacheDir()
+	}
+	return &cacheFetcher{
+		dir:     dir,
+		offline: o.Offline,
+		maxAge:  o.MaxCacheAge,
+		client:  http.DefaultClient,
+	}
+}
+
+func (f *cacheFetcher) entryPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (f *cacheFetcher) load(url string) (*cacheEntry, error) {
+	raw, err := ioutil.ReadFile(f.entryPath(url))
+	if err != nil {
+		return nil, err
+	}
+	entry := &cacheEntry{}
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (f *cacheFetcher) save(entry *cacheEntry) error {
+	if err := os.MkdirAll(f.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create upstream cache dir %q: %w", f.dir, err)
+	}
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry for %q: %w", entry.URL, err)
+	}
+	return ioutil.WriteFile(f.entryPath(entry.URL), raw, 0644)
+}
+
+// Fetch implements fetcher.
+func (f *cacheFetcher) Fetch(url string) ([]byte, error) {
+	entry, loadErr := f.load(url)
+
+	if f.offline {
+		if loadErr != nil {
+			return nil, fmt.Errorf("offline mode: no cache entry for %q: %w", url, loadErr)
+		}
+		if f.maxAge > 0 && timeNow().Sub(entry.FetchedAt) > f.maxAge {
+			return nil, fmt.Errorf("offline mode: cache entry for %q is older than MaxCacheAge (fetched at %s)", url, entry.FetchedAt)
+		}
+		return []byte(entry.Body), nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error building request for %q: %w", url, err)
+	}
+	if loadErr == nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && loadErr == nil {
+		entry.FetchedAt = timeNow()
+		if err := f.save(entry); err != nil {
+			return nil, err
+		}
+		return []byte(entry.Body), nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("request to %q returned %s", url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %q: %w", url, err)
+	}
+	if err := f.save(&cacheEntry{
+		URL:          url,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		FetchedAt:    timeNow(),
+		Body:         string(body),
+	}); err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+// timeNow is the clock used for cache entry ages and FetchedAt stamps. It is
+// a variable so that tests can replace it with a fixed clock.
+var timeNow = time.Now
+
+// httpFetcher fetches a URL straight over HTTP with no caching, preserving
+// the bumper's behavior from before UpstreamCacheDir/Offline existed.
+type httpFetcher struct{}
+
+func (httpFetcher) Fetch(url string) ([]byte, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error requesting %q: %w", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response from %q: %w", url, err)
+	}
+	return body, nil
+}
+
+// newFetcher returns the fetcher implied by o: a cacheFetcher when caching
+// or Offline mode is requested, otherwise a plain httpFetcher.
+func newFetcher(o *Options) fetcher {
+	if o.Offline || o.UpstreamCacheDir != "" || o.MaxCacheAge > 0 {
+		return newCacheFetcher(o)
+	}
+	return httpFetcher{}
+}
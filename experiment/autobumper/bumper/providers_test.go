@@ -0,0 +1,319 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bumper
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewPRProvider(t *testing.T) {
+	cases := []struct {
+		prProvider   string
+		wantTypeName string
+	}{
+		{prProvider: "", wantTypeName: "*bumper.githubProvider"},
+		{prProvider: GitHubProvider, wantTypeName: "*bumper.githubProvider"},
+		{prProvider: GitLabProvider, wantTypeName: "*bumper.gitlabProvider"},
+		{prProvider: BitbucketProvider, wantTypeName: "*bumper.bitbucketProvider"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.prProvider, func(t *testing.T) {
+			got, err := newPRProvider(&Options{PRProvider: tc.prProvider})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if gotTypeName := fmt.Sprintf("%T", got); gotTypeName != tc.wantTypeName {
+				t.Errorf("expected a %s, got a %s", tc.wantTypeName, gotTypeName)
+			}
+		})
+	}
+
+	t.Run("unknown provider is rejected", func(t *testing.T) {
+		if _, err := newPRProvider(&Options{PRProvider: "svn"}); err == nil {
+			t.Errorf("expected an error for an unknown provider, got nil")
+		}
+	})
+}
+
+func TestGitHubProviderRemoteURL(t *testing.T) {
+	p := &githubProvider{o: &Options{GitHubLogin: "k8s-ci-robot", GitHubToken: "tok", GitHubOrg: "kubernetes", GitHubRepo: "test-infra"}}
+	url, err := p.RemoteURL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(url, "k8s-ci-robot:tok@") {
+		t.Errorf("expected the remote URL to embed both the login and the token, got %q", url)
+	}
+}
+
+func TestBitbucketProviderRemoteURL(t *testing.T) {
+	p := &bitbucketProvider{o: &Options{BitbucketUser: "bot", BitbucketAppPassword: "pass", BitbucketWorkspace: "ws", BitbucketRepo: "repo"}}
+	url, err := p.RemoteURL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(url, "bot:pass@") {
+		t.Errorf("expected the remote URL to embed both the user and the app password, got %q", url)
+	}
+	if !strings.Contains(url, "@bitbucket.org/") {
+		t.Errorf("expected the remote URL to default to bitbucket.org, got %q", url)
+	}
+}
+
+func TestBitbucketProviderSelfHosted(t *testing.T) {
+	p := &bitbucketProvider{o: &Options{BitbucketUser: "bot", BitbucketAppPassword: "pass", BitbucketWorkspace: "ws", BitbucketRepo: "repo", BitbucketHost: "bitbucket.example.com", BitbucketAPIBase: "https://bitbucket.example.com/rest/api/2.0"}}
+	url, err := p.RemoteURL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(url, "@bitbucket.example.com/") {
+		t.Errorf("expected the remote URL to use BitbucketHost, got %q", url)
+	}
+	if got, want := p.api(), "https://bitbucket.example.com/rest/api/2.0"; got != want {
+		t.Errorf("expected api() to use BitbucketAPIBase, got %q, want %q", got, want)
+	}
+}
+
+func TestGitLabProviderRemoteURL(t *testing.T) {
+	p := &gitlabProvider{o: &Options{GitLabToken: "tok", GitLabProjectID: "123"}}
+	url, err := p.RemoteURL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(url, "oauth2:tok@") {
+		t.Errorf("expected the remote URL to embed the token, got %q", url)
+	}
+	if !strings.Contains(url, "gitlab.com/") {
+		t.Errorf("expected the remote URL to default to gitlab.com, got %q", url)
+	}
+}
+
+func TestGitLabProviderSelfHosted(t *testing.T) {
+	p := &gitlabProvider{o: &Options{GitLabToken: "tok", GitLabProjectID: "123", GitLabHost: "gitlab.example.com", GitLabAPIBase: "https://gitlab.example.com/api/v4"}}
+	url, err := p.RemoteURL()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(url, "@gitlab.example.com/") {
+		t.Errorf("expected the remote URL to use GitLabHost, got %q", url)
+	}
+	if got, want := p.api(), "https://gitlab.example.com/api/v4"; got != want {
+		t.Errorf("expected api() to use GitLabAPIBase, got %q, want %q", got, want)
+	}
+}
+
+func TestGitHubProviderEnsurePR(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("Authorization"), "token whatever-token"; got != want {
+			t.Errorf("expected Authorization header %q, got %q", want, got)
+		}
+		if req.Method == http.MethodGet {
+			json.NewEncoder(res).Encode([]githubPullRequest{})
+			return
+		}
+		var body map[string]string
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode request body: %v", err)
+		}
+		if !strings.Contains(body["body"], "/cc @whatever-assignee") {
+			t.Errorf("expected the body to include the assignee note, got %q", body["body"])
+		}
+		res.WriteHeader(http.StatusCreated)
+		json.NewEncoder(res).Encode(map[string]string{"html_url": "https://github.com/whatever-org/whatever-repo/pull/1"})
+	}))
+	defer testServer.Close()
+
+	p := &githubProvider{
+		o:       &Options{GitHubOrg: "whatever-org", GitHubRepo: "whatever-repo", GitHubToken: "whatever-token"},
+		apiBase: testServer.URL,
+	}
+	prURL, err := p.EnsurePR("title", "body", "whatever-assignee", "autobump-branch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prURL != "https://github.com/whatever-org/whatever-repo/pull/1" {
+		t.Errorf("unexpected PR URL: %q", prURL)
+	}
+}
+
+func TestGitHubProviderEnsurePRUpdatesExisting(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			json.NewEncoder(res).Encode([]githubPullRequest{{Number: 7, HTMLURL: "https://github.com/whatever-org/whatever-repo/pull/7"}})
+			return
+		}
+		if got, want := req.Method, http.MethodPatch; got != want {
+			t.Errorf("expected a %s to update the existing PR, got a %s", want, got)
+		}
+		if got, want := req.URL.Path, "/repos/whatever-org/whatever-repo/pulls/7"; got != want {
+			t.Errorf("expected the update to target %q, got %q", want, got)
+		}
+		json.NewEncoder(res).Encode(map[string]string{"html_url": "https://github.com/whatever-org/whatever-repo/pull/7"})
+	}))
+	defer testServer.Close()
+
+	p := &githubProvider{
+		o:       &Options{GitHubOrg: "whatever-org", GitHubRepo: "whatever-repo", GitHubToken: "whatever-token"},
+		apiBase: testServer.URL,
+	}
+	prURL, err := p.EnsurePR("title", "body", "", "autobump-branch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prURL != "https://github.com/whatever-org/whatever-repo/pull/7" {
+		t.Errorf("unexpected PR URL: %q", prURL)
+	}
+}
+
+func TestGitHubProviderEnsurePRFailure(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			json.NewEncoder(res).Encode([]githubPullRequest{})
+			return
+		}
+		res.WriteHeader(http.StatusUnprocessableEntity)
+		res.Write([]byte(`{"message":"Validation Failed"}`))
+	}))
+	defer testServer.Close()
+
+	p := &githubProvider{o: &Options{GitHubOrg: "o", GitHubRepo: "r", GitHubToken: "t"}, apiBase: testServer.URL}
+	if _, err := p.EnsurePR("title", "body", "", "branch"); err == nil {
+		t.Errorf("expected an error for a non-2xx response, got nil")
+	}
+}
+
+func TestGitLabProviderEnsurePR(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if got, want := req.Header.Get("PRIVATE-TOKEN"), "whatever-token"; got != want {
+			t.Errorf("expected PRIVATE-TOKEN header %q, got %q", want, got)
+		}
+		if req.Method == http.MethodGet {
+			json.NewEncoder(res).Encode([]gitlabMergeRequest{})
+			return
+		}
+		res.WriteHeader(http.StatusCreated)
+		json.NewEncoder(res).Encode(map[string]string{"web_url": "https://gitlab.com/whatever/project/-/merge_requests/1"})
+	}))
+	defer testServer.Close()
+
+	p := &gitlabProvider{
+		o:       &Options{GitLabProjectID: "123", GitLabToken: "whatever-token"},
+		apiBase: testServer.URL,
+	}
+	mrURL, err := p.EnsurePR("title", "body", "", "autobump-branch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mrURL != "https://gitlab.com/whatever/project/-/merge_requests/1" {
+		t.Errorf("unexpected MR URL: %q", mrURL)
+	}
+}
+
+func TestGitLabProviderEnsurePRUpdatesExisting(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			json.NewEncoder(res).Encode([]gitlabMergeRequest{{IID: 9, WebURL: "https://gitlab.com/whatever/project/-/merge_requests/9"}})
+			return
+		}
+		if got, want := req.Method, http.MethodPut; got != want {
+			t.Errorf("expected a %s to update the existing MR, got a %s", want, got)
+		}
+		json.NewEncoder(res).Encode(map[string]string{"web_url": "https://gitlab.com/whatever/project/-/merge_requests/9"})
+	}))
+	defer testServer.Close()
+
+	p := &gitlabProvider{
+		o:       &Options{GitLabProjectID: "123", GitLabToken: "whatever-token"},
+		apiBase: testServer.URL,
+	}
+	mrURL, err := p.EnsurePR("title", "body", "", "autobump-branch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mrURL != "https://gitlab.com/whatever/project/-/merge_requests/9" {
+		t.Errorf("unexpected MR URL: %q", mrURL)
+	}
+}
+
+func TestBitbucketProviderEnsurePR(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") == "" {
+			t.Errorf("expected a Basic Authorization header, got none")
+		}
+		if req.Method == http.MethodGet {
+			json.NewEncoder(res).Encode(map[string]interface{}{"values": []bitbucketPullRequest{}})
+			return
+		}
+		res.WriteHeader(http.StatusCreated)
+		json.NewEncoder(res).Encode(map[string]interface{}{
+			"links": map[string]interface{}{
+				"html": map[string]string{"href": "https://bitbucket.org/ws/repo/pull-requests/1"},
+			},
+		})
+	}))
+	defer testServer.Close()
+
+	p := &bitbucketProvider{
+		o:       &Options{BitbucketWorkspace: "ws", BitbucketRepo: "repo", BitbucketUser: "bot", BitbucketAppPassword: "pass"},
+		apiBase: testServer.URL,
+	}
+	prURL, err := p.EnsurePR("title", "body", "", "autobump-branch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prURL != "https://bitbucket.org/ws/repo/pull-requests/1" {
+		t.Errorf("unexpected PR URL: %q", prURL)
+	}
+}
+
+func TestBitbucketProviderEnsurePRUpdatesExisting(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodGet {
+			existing := bitbucketPullRequest{ID: 3}
+			existing.Source.Branch.Name = "autobump-branch"
+			existing.Links.HTML.Href = "https://bitbucket.org/ws/repo/pull-requests/3"
+			json.NewEncoder(res).Encode(map[string]interface{}{"values": []bitbucketPullRequest{existing}})
+			return
+		}
+		if got, want := req.Method, http.MethodPut; got != want {
+			t.Errorf("expected a %s to update the existing PR, got a %s", want, got)
+		}
+		json.NewEncoder(res).Encode(map[string]interface{}{
+			"links": map[string]interface{}{
+				"html": map[string]string{"href": "https://bitbucket.org/ws/repo/pull-requests/3"},
+			},
+		})
+	}))
+	defer testServer.Close()
+
+	p := &bitbucketProvider{
+		o:       &Options{BitbucketWorkspace: "ws", BitbucketRepo: "repo", BitbucketUser: "bot", BitbucketAppPassword: "pass"},
+		apiBase: testServer.URL,
+	}
+	prURL, err := p.EnsurePR("title", "body", "", "autobump-branch")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prURL != "https://bitbucket.org/ws/repo/pull-requests/3" {
+		t.Errorf("unexpected PR URL: %q", prURL)
+	}
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bumper
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Run resolves the version to bump to, commits the already-applied bump to
+// the current git checkout using CommitMessageTemplate, and, unless
+// SkipPullRequest is set, pushes the result and opens a pull/merge request
+// using PRTitleTemplate/PRBodyTemplate, pushing to the branch rendered from
+// RemoteBranchTemplate, or to RemoteBranch when that template is unset. It
+// returns the
+// URL of the opened pull/merge request, or "" when SkipPullRequest is true.
+func Run(o *Options) (string, error) {
+	if err := validateOptions(o); err != nil {
+		return "", err
+	}
+
+	toVersion, digest, err := resolveToVersion(o)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve the upstream version to bump to: %w", err)
+	}
+
+	gitCtx, err := gatherGitContext()
+	if err != nil {
+		return "", fmt.Errorf("failed to gather git context: %w", err)
+	}
+
+	changed, err := changedConfigPaths(o)
+	if err != nil {
+		return "", fmt.Errorf("failed to determine changed config paths: %w", err)
+	}
+
+	bumpCtx := BumpContext{
+		FromVersion:        o.CurrentVersion,
+		ToVersion:          toVersion,
+		ProwImages:         bumpedImages(o.BumpProwImages, toVersion),
+		TestImages:         bumpedImages(o.BumpTestImages, toVersion),
+		Digest:             digest,
+		Oncaller:           getAssignment(o.OncallAddress),
+		ChangedConfigPaths: changed,
+	}
+	tmplCtx := newTemplateContext(timeNow(), gitCtx, bumpCtx)
+
+	commitMessage, err := renderTemplate("CommitMessageTemplate", templateOrDefault(o.CommitMessageTemplate, defaultCommitMessageTemplate), tmplCtx)
+	if err != nil {
+		return "", err
+	}
+	prTitle, err := renderTemplate("PRTitleTemplate", templateOrDefault(o.PRTitleTemplate, defaultPRTitleTemplate), tmplCtx)
+	if err != nil {
+		return "", err
+	}
+	prBody, err := renderTemplate("PRBodyTemplate", templateOrDefault(o.PRBodyTemplate, defaultPRBodyTemplate), tmplCtx)
+	if err != nil {
+		return "", err
+	}
+	branch := o.RemoteBranch
+	if o.RemoteBranchTemplate != "" || branch == "" {
+		branch, err = renderTemplate("RemoteBranchTemplate", templateOrDefault(o.RemoteBranchTemplate, defaultRemoteBranchTemplate), tmplCtx)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if err := call(&stdout, &stderr, "git", "commit", "-am", commitMessage); err != nil {
+		return "", fmt.Errorf("failed to commit the bump: %w: %s", err, stderr.String())
+	}
+
+	if o.SkipPullRequest {
+		return "", nil
+	}
+
+	prov, err := newPRProvider(o)
+	if err != nil {
+		return "", err
+	}
+	remote, err := prov.RemoteURL()
+	if err != nil {
+		return "", err
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if err := call(&stdout, &stderr, "git", "push", remote, fmt.Sprintf("HEAD:refs/heads/%s", branch)); err != nil {
+		return "", fmt.Errorf("failed to push the bump branch: %w: %s", err, stderr.String())
+	}
+
+	return prov.EnsurePR(prTitle, prBody, oncallAssignee(bumpCtx.Oncaller), branch)
+}
+
+// bumpedImages returns the placeholder BumpContext.ProwImages/TestImages map
+// default templates branch on to describe which image set was bumped, or nil
+// when bump is false so that branch is falsy in text/template.
+func bumpedImages(bump bool, toVersion string) map[string]string {
+	if !bump {
+		return nil
+	}
+	return map[string]string{"*": toVersion}
+}
+
+// resolveToVersion returns the version to bump to and, when VerifySignatures
+// is set, the digest it was verified against. Options.TargetVersion is
+// returned verbatim when it pins a concrete version, in which case no
+// verification happens, since there is no upstream fetch to verify against
+// (validateOptions rejects VerifySignatures together with a pinned
+// TargetVersion, so this path is never reachable when VerifySignatures is
+// true); otherwise both are resolved from Options.UpstreamURLBase via
+// parseUpstreamImageVersion.
+func resolveToVersion(o *Options) (version, digest string, err error) {
+	if o.TargetVersion != "" && o.TargetVersion != latestVersion {
+		return o.TargetVersion, "", nil
+	}
+	return parseUpstreamImageVersion(o.UpstreamURLBase, newVerifier(o), newFetcher(o))
+}
+
+// changedConfigPaths returns the subset of files with uncommitted changes
+// that fall under Options.IncludedConfigPaths and not under
+// Options.ExcludedConfigPaths.
+func changedConfigPaths(o *Options) ([]string, error) {
+	out, err := gitOutput("diff", "--name-only", "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	if out == "" {
+		return nil, nil
+	}
+	var changed []string
+	for _, f := range strings.Split(out, "\n") {
+		if isUnderPath(f, o.IncludedConfigPaths) && !isUnderPath(f, o.ExcludedConfigPaths) {
+			changed = append(changed, f)
+		}
+	}
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// gatherGitContext inspects the current git checkout to build the GitContext
+// exposed to *Template Options fields.
+func gatherGitContext() (GitContext, error) {
+	branch, err := gitOutput("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return GitContext{}, err
+	}
+	shortCommit, err := gitOutput("rev-parse", "--short", "HEAD")
+	if err != nil {
+		return GitContext{}, err
+	}
+	fullCommit, err := gitOutput("rev-parse", "HEAD")
+	if err != nil {
+		return GitContext{}, err
+	}
+	commitDate, err := gitOutput("log", "-1", "--format=%cI")
+	if err != nil {
+		return GitContext{}, err
+	}
+	commitTimestampStr, err := gitOutput("log", "-1", "--format=%ct")
+	if err != nil {
+		return GitContext{}, err
+	}
+	commitTimestamp, err := strconv.ParseInt(commitTimestampStr, 10, 64)
+	if err != nil {
+		return GitContext{}, fmt.Errorf("failed to parse commit timestamp %q: %w", commitTimestampStr, err)
+	}
+	status, err := gitOutput("status", "--porcelain")
+	if err != nil {
+		return GitContext{}, err
+	}
+	// Tags are optional: not every checkout is built from a tagged commit.
+	tag, _ := gitOutput("describe", "--tags", "--abbrev=0")
+
+	return GitContext{
+		Branch:          branch,
+		Tag:             tag,
+		ShortCommit:     shortCommit,
+		FullCommit:      fullCommit,
+		CommitDate:      commitDate,
+		CommitTimestamp: commitTimestamp,
+		IsDirty:         status != "",
+	}, nil
+}
+
+// gitOutput runs `git <args...>` and returns its trimmed stdout.
+func gitOutput(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := call(&stdout, &stderr, "git", args...); err != nil {
+		return "", fmt.Errorf("git %s failed: %w: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// oncallAssignee extracts the oncaller's username from the string
+// getAssignment returns, or "" if it doesn't describe an assignable
+// oncaller (e.g. it's empty, an error message, or nobody is on call).
+func oncallAssignee(oncaller string) string {
+	const prefix = "test-infra oncall: "
+	if !strings.HasPrefix(oncaller, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(oncaller, prefix)
+}
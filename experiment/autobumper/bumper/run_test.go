@@ -0,0 +1,151 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bumper
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// initTestRepo creates a throwaway git repo with one committed file and one
+// uncommitted change under "config/prow/", and chdirs the test into it.
+func initTestRepo(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("failed to get working directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(cwd) })
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("failed to chdir to %q: %v", dir, err)
+	}
+
+	mustRun := func(name string, args ...string) {
+		var stdout, stderr bytes.Buffer
+		if err := call(&stdout, &stderr, name, args...); err != nil {
+			t.Fatalf("%s %v failed: %v: %s", name, args, err, stderr.String())
+		}
+	}
+	mustRun("git", "init", "-q")
+	mustRun("git", "config", "user.email", "whatever@example.com")
+	mustRun("git", "config", "user.name", "whatever-name")
+
+	if err := os.MkdirAll(filepath.Join(dir, "config", "prow"), 0755); err != nil {
+		t.Fatalf("failed to create config dir: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config", "prow", "config.yaml"), []byte("image: gcr.io/k8s-prow/deck:v1\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	mustRun("git", "add", "-A")
+	mustRun("git", "commit", "-q", "-m", "initial commit")
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "config", "prow", "config.yaml"), []byte("image: gcr.io/k8s-prow/deck:v2\n"), 0644); err != nil {
+		t.Fatalf("failed to write bumped config file: %v", err)
+	}
+}
+
+func TestRunRendersTemplatesAndCommits(t *testing.T) {
+	initTestRepo(t)
+
+	o := &Options{
+		BumpProwImages:        true,
+		TargetVersion:         "v2",
+		IncludedConfigPaths:   []string{"config/prow/"},
+		SkipPullRequest:       true,
+		CommitMessageTemplate: "Bump to {{.Bump.ToVersion}}",
+	}
+
+	prURL, err := Run(o)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if prURL != "" {
+		t.Errorf("expected no PR URL when SkipPullRequest is true, got %q", prURL)
+	}
+
+	msg, err := gitOutput("log", "-1", "--format=%s")
+	if err != nil {
+		t.Fatalf("failed to read commit message: %v", err)
+	}
+	if want := "Bump to v2"; msg != want {
+		t.Errorf("expected commit message %q, got %q", want, msg)
+	}
+
+	status, err := gitOutput("status", "--porcelain")
+	if err != nil {
+		t.Fatalf("failed to read git status: %v", err)
+	}
+	if status != "" {
+		t.Errorf("expected a clean tree after Run committed the bump, got status %q", status)
+	}
+}
+
+func TestRunDefaultCommitMessage(t *testing.T) {
+	cases := []struct {
+		description    string
+		bumpProwImages bool
+		bumpTestImages bool
+		wantSubstring  string
+	}{
+		{description: "prow images only", bumpProwImages: true, wantSubstring: "Update prow images to v2"},
+		{description: "test images only", bumpTestImages: true, wantSubstring: "Update test images to v2"},
+		{description: "both prow and test images", bumpProwImages: true, bumpTestImages: true, wantSubstring: "Update prow and test images to v2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.description, func(t *testing.T) {
+			initTestRepo(t)
+
+			o := &Options{
+				BumpProwImages:      tc.bumpProwImages,
+				BumpTestImages:      tc.bumpTestImages,
+				IncludedConfigPaths: []string{"config/prow/"},
+				SkipPullRequest:     true,
+			}
+			if tc.bumpProwImages && tc.bumpTestImages {
+				// validateOptions requires latestVersion (resolved from
+				// upstream) rather than a pinned TargetVersion when both
+				// image sets are bumped together.
+				testServer := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+					res.Write([]byte("image: gcr.io/k8s-prow/deck:v2"))
+				}))
+				defer testServer.Close()
+				o.UpstreamURLBase = testServer.URL
+			} else {
+				o.TargetVersion = "v2"
+			}
+
+			if _, err := Run(o); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			msg, err := gitOutput("log", "-1", "--format=%s")
+			if err != nil {
+				t.Fatalf("failed to read commit message: %v", err)
+			}
+			if msg != tc.wantSubstring {
+				t.Errorf("expected default commit message %q, got %q", tc.wantSubstring, msg)
+			}
+		})
+	}
+}
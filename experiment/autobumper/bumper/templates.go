@@ -0,0 +1,149 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bumper
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// defaultCommitMessageTemplate, defaultPRTitleTemplate and
+// defaultPRBodyTemplate preserve the bumper's historical, hard-coded behavior
+// for callers that don't set the *Template Options fields.
+// defaultRemoteBranchTemplate is used only when both RemoteBranchTemplate and
+// Options.RemoteBranch are empty; otherwise Run pushes to RemoteBranch.
+const (
+	defaultCommitMessageTemplate = `Update {{if .Bump.ProwImages}}prow{{if .Bump.TestImages}} and test{{end}}{{else}}test{{end}} images to {{.Bump.ToVersion}}`
+	defaultPRTitleTemplate       = defaultCommitMessageTemplate
+	defaultPRBodyTemplate        = `Update from {{.Bump.FromVersion}} to {{.Bump.ToVersion}}{{with .Bump.Digest}}
+
+Verified digest: {{.}}{{end}}{{with .Bump.Oncaller}}
+
+{{.}}{{end}}`
+	defaultRemoteBranchTemplate = `autobump-{{.Bump.ToVersion}}`
+)
+
+// GitContext describes the state of the local git checkout the bump is
+// being made from, for use in *Template Options fields.
+type GitContext struct {
+	Branch          string
+	Tag             string
+	ShortCommit     string
+	FullCommit      string
+	CommitDate      string
+	CommitTimestamp int64
+	IsDirty         bool
+}
+
+// BumpContext describes the bump itself, for use in *Template Options
+// fields.
+type BumpContext struct {
+	FromVersion        string
+	ToVersion          string
+	ProwImages         map[string]string
+	TestImages         map[string]string
+	// Digest is the "sha256:..." digest VerifyImage resolved ToVersion to,
+	// or "" when VerifySignatures is false.
+	Digest             string
+	Oncaller           string
+	ChangedConfigPaths []string
+}
+
+// TemplateContext is the data made available to CommitMessageTemplate,
+// PRTitleTemplate, PRBodyTemplate and RemoteBranchTemplate.
+type TemplateContext struct {
+	Env       map[string]string
+	Date      string
+	Timestamp int64
+	Git       GitContext
+	Bump      BumpContext
+}
+
+// newTemplateContext builds the TemplateContext a template will be rendered
+// against, stamping Date/Timestamp at now and Env from the process
+// environment.
+func newTemplateContext(now time.Time, git GitContext, bump BumpContext) *TemplateContext {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return &TemplateContext{
+		Env:       env,
+		Date:      now.UTC().Format(time.RFC3339),
+		Timestamp: now.Unix(),
+		Git:       git,
+		Bump:      bump,
+	}
+}
+
+// parseTemplate parses text as a named text/template, returning an error if
+// its syntax is invalid. It is used both to validate *Template Options
+// fields up front and to render them later.
+func parseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s template: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// renderTemplate parses and executes text against ctx, returning the
+// rendered string.
+func renderTemplate(name, text string, ctx *TemplateContext) (string, error) {
+	tmpl, err := parseTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, ctx); err != nil {
+		return "", fmt.Errorf("failed to render %s template: %w", name, err)
+	}
+	return buf.String(), nil
+}
+
+// templateOrDefault returns text, or def if text is empty.
+func templateOrDefault(text, def string) string {
+	if text == "" {
+		return def
+	}
+	return text
+}
+
+// validateTemplates parses every *Template field set on o (or its default)
+// so that a malformed template is rejected by validateOptions instead of
+// surfacing at commit/PR time.
+func validateTemplates(o *Options) error {
+	templates := map[string]string{
+		"CommitMessageTemplate": templateOrDefault(o.CommitMessageTemplate, defaultCommitMessageTemplate),
+		"PRTitleTemplate":       templateOrDefault(o.PRTitleTemplate, defaultPRTitleTemplate),
+		"PRBodyTemplate":        templateOrDefault(o.PRBodyTemplate, defaultPRBodyTemplate),
+		"RemoteBranchTemplate":  templateOrDefault(o.RemoteBranchTemplate, defaultRemoteBranchTemplate),
+	}
+	for name, text := range templates {
+		if _, err := parseTemplate(name, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
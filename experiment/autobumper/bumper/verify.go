@@ -0,0 +1,83 @@
+/*
+Copyright 2019 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bumper
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// signatureVerifier checks that an image reference is signed, returning the
+// digest it resolved to so callers can pin the bump to it instead of a
+// floating tag.
+type signatureVerifier interface {
+	// VerifyImage verifies imageRef (e.g. "gcr.io/k8s-prow/deck:v20200717-cf288082e1")
+	// and returns the "sha256:..." digest it verified the signature against.
+	VerifyImage(imageRef string) (digest string, err error)
+}
+
+// cosignVerifier verifies image signatures by shelling out to the cosign
+// CLI, either against a public key (a local path or a `k8s://` reference)
+// or, for keyless signing, a certificate identity/issuer pair.
+type cosignVerifier struct {
+	// keyRef is Options.SignatureKeyRef. A value containing "|" is treated
+	// as "<certificate-identity>|<certificate-oidc-issuer>" for keyless
+	// verification; anything else is passed to cosign as --key.
+	keyRef string
+}
+
+var cosignVerifiedDigestRegexp = regexp.MustCompile(`"docker-manifest-digest"\s*:\s*"(sha256:[0-9a-f]+)"`)
+
+func (v *cosignVerifier) VerifyImage(imageRef string) (string, error) {
+	args := []string{"verify"}
+	if parts := strings.SplitN(v.keyRef, "|", 2); len(parts) == 2 {
+		args = append(args, "--certificate-identity", parts[0], "--certificate-oidc-issuer", parts[1])
+	} else {
+		args = append(args, "--key", v.keyRef)
+	}
+	args = append(args, imageRef)
+
+	var stdout, stderr bytes.Buffer
+	if err := call(&stdout, &stderr, "cosign", args...); err != nil {
+		return "", fmt.Errorf("cosign verify %s failed: %w: %s", imageRef, err, stderr.String())
+	}
+	matches := cosignVerifiedDigestRegexp.FindStringSubmatch(stdout.String())
+	if matches == nil {
+		return "", fmt.Errorf("cosign verify %s succeeded but no digest was found in its output", imageRef)
+	}
+	return matches[1], nil
+}
+
+// validateVerifySignatures makes sure VerifySignatures and SignatureKeyRef
+// are set consistently.
+func validateVerifySignatures(o *Options) error {
+	if o.VerifySignatures && o.SignatureKeyRef == "" {
+		return fmt.Errorf("SignatureKeyRef must be set when VerifySignatures is true")
+	}
+	return nil
+}
+
+// newVerifier returns the signatureVerifier Run should verify the upstream
+// image against, or nil if o.VerifySignatures is false.
+func newVerifier(o *Options) signatureVerifier {
+	if !o.VerifySignatures {
+		return nil
+	}
+	return &cosignVerifier{keyRef: o.SignatureKeyRef}
+}